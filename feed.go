@@ -0,0 +1,116 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// feedSource fetches albums from a plain JSON or RSS feed listing track
+// URLs, for sites that don't need any page scraping at all.
+type feedSource struct{}
+
+func (feedSource) Match(u *url.URL) bool {
+	path := strings.ToLower(u.Path)
+	return strings.HasSuffix(path, ".json") || strings.HasSuffix(path, ".xml")
+}
+
+func (feedSource) FetchAlbum(ctx context.Context, u *url.URL) (*Album, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if strings.HasSuffix(strings.ToLower(u.Path), ".json") {
+		return parseJSONFeed(resp.Body)
+	}
+	return parseRSSFeed(resp.Body)
+}
+
+// jsonFeed is the shape expected of a JSON feed: a flat album description
+// with a list of tracks, each naming its own URL.
+type jsonFeed struct {
+	Artist string `json:"artist"`
+	Album  string `json:"album"`
+	Year   string `json:"year"`
+	Art    string `json:"art"`
+	Tracks []struct {
+		Title    string  `json:"title"`
+		TrackNum int     `json:"track_num"`
+		URL      string  `json:"url"`
+		Duration float64 `json:"duration"`
+	} `json:"tracks"`
+}
+
+func parseJSONFeed(r io.Reader) (*Album, error) {
+	var feed jsonFeed
+	if err := json.NewDecoder(r).Decode(&feed); err != nil {
+		return nil, fmt.Errorf("could not parse JSON feed: %w", err)
+	}
+
+	album := &Album{
+		Artist:      feed.Artist,
+		AlbumArtist: feed.Artist,
+		Title:       feed.Album,
+		Year:        feed.Year,
+		ArtLargeURL: feed.Art,
+	}
+	for i, t := range feed.Tracks {
+		if t.URL == "" {
+			continue
+		}
+		trackNum := t.TrackNum
+		if trackNum == 0 {
+			trackNum = i + 1
+		}
+		album.Tracks = append(album.Tracks, Track{Title: t.Title, TrackNum: trackNum, URL: t.URL, Duration: t.Duration})
+	}
+	if len(album.Tracks) == 0 {
+		return nil, fmt.Errorf("feed has no tracks")
+	}
+	return album, nil
+}
+
+// rssFeed is a minimal podcast-style RSS feed: each <item>'s <enclosure>
+// names the track's audio URL.
+type rssFeed struct {
+	XMLName xml.Name `xml:"rss"`
+	Channel struct {
+		Title string `xml:"title"`
+		Items []struct {
+			Title     string `xml:"title"`
+			Enclosure struct {
+				URL string `xml:"url,attr"`
+			} `xml:"enclosure"`
+		} `xml:"item"`
+	} `xml:"channel"`
+}
+
+func parseRSSFeed(r io.Reader) (*Album, error) {
+	var feed rssFeed
+	if err := xml.NewDecoder(r).Decode(&feed); err != nil {
+		return nil, fmt.Errorf("could not parse RSS feed: %w", err)
+	}
+
+	album := &Album{Title: feed.Channel.Title}
+	for i, item := range feed.Channel.Items {
+		if item.Enclosure.URL == "" {
+			continue
+		}
+		album.Tracks = append(album.Tracks, Track{Title: item.Title, TrackNum: i + 1, URL: item.Enclosure.URL})
+	}
+	if len(album.Tracks) == 0 {
+		return nil, fmt.Errorf("feed has no downloadable items")
+	}
+	return album, nil
+}
@@ -0,0 +1,358 @@
+package main
+
+import (
+	"archive/zip"
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	flac "github.com/go-flac/go-flac"
+	"github.com/go-flac/flacpicture"
+	"github.com/go-flac/flacvorbis"
+	"golang.org/x/net/html"
+)
+
+var format = flag.String("format", "mp3-128", "Preferred format when the album offers a free download: mp3-128 or flac. mp3-128 always uses the regular streaming file and ignores the free download. ALAC/Vorbis aren't offered yet since bandit-dl can't tag them.")
+
+// taggableFreeFormats are the free-download formats downloadFreeFormatTracks
+// knows how to tag. Bandcamp's free-download archives can also contain ALAC
+// (.m4a) and Vorbis (.ogg), but until bandit-dl can write MP4 atoms and Ogg
+// Vorbis comments respectively, offering those formats would silently ship
+// untagged files, so --format rejects them instead.
+var taggableFreeFormats = map[string]bool{
+	"flac": true,
+}
+
+// bandcampFreeDownloadBlob mirrors the "data-blob" JSON embedded in a
+// Bandcamp free-download page.
+type bandcampFreeDownloadBlob struct {
+	DigitalItems []struct {
+		Downloads map[string]struct {
+			Url string `json:"url"`
+		} `json:"downloads"`
+	} `json:"digital_items"`
+}
+
+// fetchFreeDownloadArchive resolves the free-download page for an album to
+// the final archive URL for the given format, polling Bandcamp's
+// "statdownload" endpoint until the archive is ready.
+func fetchFreeDownloadArchive(ctx context.Context, freeDownloadPage, format string) (string, error) {
+	resp, err := http.Get(freeDownloadPage)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	doc, err := html.Parse(resp.Body)
+	if err != nil {
+		return "", err
+	}
+
+	el := findElementsWithDataKey(doc, "data-blob")
+	if el == nil {
+		return "", fmt.Errorf("could not find free download info")
+	}
+
+	var blob bandcampFreeDownloadBlob
+	if err := json.Unmarshal([]byte(getDataValue(el[0], "data-blob")), &blob); err != nil {
+		return "", fmt.Errorf("could not parse free download info: %w", err)
+	}
+	if len(blob.DigitalItems) == 0 {
+		return "", fmt.Errorf("no digital items on free download page")
+	}
+
+	dl, ok := blob.DigitalItems[0].Downloads[format]
+	if !ok {
+		return "", fmt.Errorf("format %q not offered for this release", format)
+	}
+
+	return resolveFreeDownloadZipURL(ctx, dl.Url)
+}
+
+// resolveFreeDownloadZipURL follows Bandcamp's download link through its
+// "statdownload" polling endpoint, which reports the archive as "pending"
+// while it's assembled server-side and "ok" (with the real archive URL)
+// once it's ready.
+func resolveFreeDownloadZipURL(ctx context.Context, downloadURL string) (string, error) {
+	statURL := strings.Replace(downloadURL, "/download/", "/statdownload/", 1)
+	if strings.Contains(statURL, "?") {
+		statURL += "&.vrs=1"
+	} else {
+		statURL += "?.vrs=1"
+	}
+
+	const maxAttempts = 10
+	for attempt := 0; attempt < maxAttempts; attempt++ {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, statURL, nil)
+		if err != nil {
+			return "", err
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			return "", err
+		}
+		body, err := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			return "", err
+		}
+
+		// The response is wrapped in a JSONP-style callback; pull out the
+		// JSON object itself (the "{" that starts it is followed directly
+		// by a quoted key, unlike the callback's own braces).
+		text := string(body)
+		start := strings.Index(text, `{"`)
+		if start < 0 {
+			return "", fmt.Errorf("unexpected statdownload response")
+		}
+		end := matchingBrace(text, start)
+		if end < 0 {
+			return "", fmt.Errorf("unexpected statdownload response")
+		}
+
+		var stat struct {
+			Result      string `json:"result"`
+			DownloadURL string `json:"download_url"`
+		}
+		if err := json.Unmarshal([]byte(text[start:end+1]), &stat); err != nil {
+			return "", fmt.Errorf("could not parse statdownload response: %w", err)
+		}
+		if stat.Result == "ok" && stat.DownloadURL != "" {
+			return stat.DownloadURL, nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return "", ctx.Err()
+		case <-time.After(time.Duration(attempt+1) * 500 * time.Millisecond):
+		}
+	}
+
+	return "", fmt.Errorf("timed out waiting for bandcamp to prepare the download")
+}
+
+// matchingBrace returns the index of the "}" that closes the "{" at start,
+// skipping over braces inside quoted strings.
+func matchingBrace(text string, start int) int {
+	depth := 0
+	inString, escaped := false, false
+	for i := start; i < len(text); i++ {
+		c := text[i]
+		switch {
+		case escaped:
+			escaped = false
+		case inString && c == '\\':
+			escaped = true
+		case c == '"':
+			inString = !inString
+		case inString:
+		case c == '{':
+			depth++
+		case c == '}':
+			depth--
+			if depth == 0 {
+				return i
+			}
+		}
+	}
+	return -1
+}
+
+// audioArchiveExtensions are the file extensions downloadArchive treats as
+// tracks rather than incidental archive members (info text files, etc).
+var audioArchiveExtensions = map[string]bool{
+	".flac": true, ".m4a": true, ".ogg": true, ".aac": true, ".aiff": true, ".wav": true,
+}
+
+// downloadArchive fetches the zip at url and extracts its audio files into
+// destDir, returning their paths sorted by name (which for Bandcamp
+// archives means track order, since entries are prefixed "01 ...", "02
+// ...", etc).
+func downloadArchive(url, destDir string) ([]string, error) {
+	resp, err := http.Get(url)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	tmpZip, err := os.CreateTemp("", "bandit-dl-*.zip")
+	if err != nil {
+		return nil, err
+	}
+	defer os.Remove(tmpZip.Name())
+	defer tmpZip.Close()
+
+	if _, err := io.Copy(tmpZip, resp.Body); err != nil {
+		return nil, fmt.Errorf("could not download archive: %w", err)
+	}
+
+	zr, err := zip.OpenReader(tmpZip.Name())
+	if err != nil {
+		return nil, fmt.Errorf("could not open archive: %w", err)
+	}
+	defer zr.Close()
+
+	var paths []string
+	for _, zf := range zr.File {
+		if !audioArchiveExtensions[strings.ToLower(filepath.Ext(zf.Name))] {
+			continue
+		}
+		if err := extractZipFile(zf, destDir); err != nil {
+			return nil, err
+		}
+		paths = append(paths, filepath.Join(destDir, filepath.Base(zf.Name)))
+	}
+	if len(paths) == 0 {
+		return nil, fmt.Errorf("archive had no recognized audio files")
+	}
+
+	sort.Strings(paths)
+	return paths, nil
+}
+
+func extractZipFile(zf *zip.File, destDir string) error {
+	rc, err := zf.Open()
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+
+	out, err := os.Create(filepath.Join(destDir, filepath.Base(zf.Name)))
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+
+	_, err = io.Copy(out, rc)
+	return err
+}
+
+// downloadFreeFormatTracks downloads the album's free-download archive in
+// *format, extracts it, moves each file into albumPath under *trackFormat,
+// and tags it. It returns an error (leaving the caller to fall back to the
+// regular mp3-128 path) if the free download isn't available in *format.
+// Unlike the regular per-track path, this always re-downloads the whole
+// archive; --resume has nothing to resume against. --verify still writes
+// manifest.json, since the extracted files benefit from the same
+// tamper/corruption check as mp3-128 downloads.
+func downloadFreeFormatTracks(album *Album, albumPath string, pathValues map[string]string, artBytes []byte) error {
+	if !taggableFreeFormats[*format] {
+		return fmt.Errorf("tagging for format %q isn't implemented yet", *format)
+	}
+
+	zipURL, err := fetchFreeDownloadArchive(context.Background(), album.FreeDownloadPage, *format)
+	if err != nil {
+		return err
+	}
+
+	tmpDir, err := os.MkdirTemp("", "bandit-dl-extract-*")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(tmpDir)
+
+	files, err := downloadArchive(zipURL, tmpDir)
+	if err != nil {
+		return err
+	}
+	if len(files) != len(album.Tracks) {
+		return fmt.Errorf("archive had %d audio files but album has %d tracks", len(files), len(album.Tracks))
+	}
+
+	var manifestOut []manifestTrack
+	for i, track := range album.Tracks {
+		ext := strings.ToLower(filepath.Ext(files[i]))
+
+		values := make(map[string]string, len(pathValues)+1)
+		for k, v := range pathValues {
+			values[k] = v
+		}
+		values["title"] = track.Title
+		trackName := filepath.FromSlash(formatTemplate(*trackFormat, values, track.TrackNum)) + ext
+		dest := filepath.Join(albumPath, trackName)
+		if err := ensureWithinWorkingDir(dest); err != nil {
+			return err
+		}
+
+		if err := os.MkdirAll(filepath.Dir(dest), 0755); err != nil {
+			return fmt.Errorf("could not create track directory: %w", err)
+		}
+		if err := os.Rename(files[i], dest); err != nil {
+			return fmt.Errorf("could not move track file: %w", err)
+		}
+
+		switch ext {
+		case ".flac":
+			if err := tagFLAC(dest, album, track, artBytes); err != nil {
+				fmt.Println("⚠️ could not tag", dest, err)
+			}
+		default:
+			// *format is validated against taggableFreeFormats above, so this
+			// only fires if Bandcamp's archive held a file extension we
+			// didn't expect for the requested format.
+			return fmt.Errorf("don't know how to tag %q file %s", ext, dest)
+		}
+
+		if *verify {
+			sha, size, err := sha256File(dest)
+			if err != nil {
+				return fmt.Errorf("could not hash track file: %w", err)
+			}
+			manifestOut = append(manifestOut, manifestTrack{Filename: trackName, Size: size, SHA256: sha, Source: zipURL})
+		}
+	}
+
+	if *verify {
+		m := &albumManifest{Artist: album.Artist, Album: album.Title, Year: album.Year, Tracks: manifestOut}
+		if err := saveManifest(albumPath, m); err != nil {
+			return fmt.Errorf("could not write manifest: %w", err)
+		}
+	}
+
+	return nil
+}
+
+// tagFLAC rewrites the FLAC file's Vorbis comment and picture metadata
+// blocks in place.
+func tagFLAC(path string, album *Album, track Track, artBytes []byte) error {
+	f, err := flac.ParseFile(path)
+	if err != nil {
+		return fmt.Errorf("could not parse flac file: %w", err)
+	}
+
+	var blocks []*flac.MetaDataBlock
+	for _, b := range f.Meta {
+		if b.Type != flac.VorbisComment && b.Type != flac.Picture {
+			blocks = append(blocks, b)
+		}
+	}
+
+	cmt := flacvorbis.New()
+	cmt.Add(flacvorbis.FIELD_TITLE, track.Title)
+	cmt.Add(flacvorbis.FIELD_ARTIST, album.Artist)
+	cmt.Add(flacvorbis.FIELD_ALBUM, album.Title)
+	cmt.Add(flacvorbis.FIELD_TRACKNUMBER, fmt.Sprintf("%d", track.TrackNum))
+	cmt.Add(flacvorbis.FIELD_DATE, album.Year)
+	cmtMeta := cmt.Marshal()
+	blocks = append(blocks, &cmtMeta)
+
+	if artBytes != nil {
+		pic, err := flacpicture.NewFromImageData(flacpicture.PictureTypeFrontCover, "Front cover", artBytes, "image/jpeg")
+		if err != nil {
+			return fmt.Errorf("could not build flac picture: %w", err)
+		}
+		picMeta := pic.Marshal()
+		blocks = append(blocks, &picMeta)
+	}
+
+	f.Meta = blocks
+	return f.Save(path)
+}
@@ -0,0 +1,201 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/bogem/id3v2/v2"
+)
+
+var saveLRC = flag.Bool("save-lrc", false, "Save fetched lyrics as a .lrc sidecar file next to each track")
+var embedLRC = flag.Bool("embed-lrc", true, "Embed fetched lyrics into the track's ID3 tag")
+var lyricsProvider = flag.String("lyrics-provider", "none", "External provider to fetch synchronized lyrics from (lrclib, none)")
+
+const lrclibAPIUrl = "https://lrclib.net/api/get"
+
+// lrclibClient bounds how long a stalled or unresponsive LRCLIB request can
+// block a track's lyrics resolution.
+var lrclibClient = &http.Client{Timeout: 10 * time.Second}
+
+// lrclibResponse mirrors the fields we care about from LRCLIB's /api/get
+// response.
+type lrclibResponse struct {
+	SyncedLyrics string `json:"syncedLyrics"`
+	PlainLyrics  string `json:"plainLyrics"`
+}
+
+// lrcLine is a single timestamped line parsed out of an LRC-format lyrics
+// blob.
+type lrcLine struct {
+	TimestampMS uint32
+	Text        string
+}
+
+var lrcTagPattern = regexp.MustCompile(`^\[(\d+):(\d+(?:\.\d+)?)\]`)
+
+// parseLRC splits an LRC-format lyrics blob into timestamped lines, skipping
+// any line that isn't a timed lyric line (e.g. metadata tags like "[ar:...]").
+// A line may carry more than one leading timestamp tag (LRC's way of marking
+// a repeated section, e.g. "[00:01.00][00:02.00]Repeated line"); each tag on
+// such a line produces its own lrcLine sharing the line's text.
+func parseLRC(lrc string) []lrcLine {
+	var lines []lrcLine
+	for _, raw := range strings.Split(lrc, "\n") {
+		raw = strings.TrimRight(raw, "\r")
+
+		var timestamps []uint32
+		rest := raw
+		for {
+			m := lrcTagPattern.FindStringSubmatchIndex(rest)
+			if m == nil {
+				break
+			}
+			minutes, err := strconv.Atoi(rest[m[2]:m[3]])
+			if err != nil {
+				break
+			}
+			seconds, err := strconv.ParseFloat(rest[m[4]:m[5]], 64)
+			if err != nil {
+				break
+			}
+			timestamps = append(timestamps, uint32(minutes)*60000+uint32(seconds*1000))
+			rest = rest[m[1]:]
+		}
+		if len(timestamps) == 0 {
+			continue
+		}
+
+		text := strings.TrimSpace(rest)
+		for _, ts := range timestamps {
+			lines = append(lines, lrcLine{TimestampMS: ts, Text: text})
+		}
+	}
+	return lines
+}
+
+// fetchLRCLibLyrics queries LRCLIB for synced and plain lyrics matching the
+// given track. A nil response with a nil error means LRCLIB has no match.
+func fetchLRCLibLyrics(artist, album, title string, durationSeconds int) (*lrclibResponse, error) {
+	q := url.Values{}
+	q.Set("artist_name", artist)
+	q.Set("track_name", title)
+	q.Set("album_name", album)
+	if durationSeconds > 0 {
+		q.Set("duration", strconv.Itoa(durationSeconds))
+	}
+
+	resp, err := lrclibClient.Get(lrclibAPIUrl + "?" + q.Encode())
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, nil
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("lrclib returned status %s", resp.Status)
+	}
+
+	var out lrclibResponse
+	if err := json.NewDecoder(resp.Body).Decode(&out); err != nil {
+		return nil, fmt.Errorf("could not parse lrclib response: %w", err)
+	}
+	return &out, nil
+}
+
+// resolvedLyrics is the outcome of resolving a track's lyrics from its
+// source and an optional external provider, ready to be written to a
+// sidecar file or embedded into an ID3 tag. It holds no reference to the
+// track file or its tag, so resolveLyrics is safe to call without holding
+// the per-track tagging lock.
+type resolvedLyrics struct {
+	plain   string
+	lrcText string // raw LRC text, used for the .lrc sidecar when available
+	synced  []lrcLine
+}
+
+func (r resolvedLyrics) empty() bool {
+	return r.plain == "" && len(r.synced) == 0
+}
+
+// resolveLyrics fetches lyrics for a track from the source's own embedded
+// lyrics and, if --lyrics-provider isn't "none", an external provider. It
+// performs the (potentially slow) network round-trip, but does no file or
+// tag I/O.
+func resolveLyrics(artist, album, title string, durationSeconds float64, sourceLyrics string) (resolvedLyrics, error) {
+	res := resolvedLyrics{plain: sourceLyrics}
+
+	if *lyricsProvider != "none" {
+		lrclib, err := fetchLRCLibLyrics(artist, album, title, int(durationSeconds))
+		if err != nil {
+			return resolvedLyrics{}, err
+		}
+		if lrclib != nil {
+			if lrclib.SyncedLyrics != "" {
+				res.lrcText = lrclib.SyncedLyrics
+				res.synced = parseLRC(lrclib.SyncedLyrics)
+			}
+			if lrclib.PlainLyrics != "" {
+				res.plain = lrclib.PlainLyrics
+			}
+		}
+	}
+
+	return res, nil
+}
+
+// applyLyrics writes an already-resolved resolvedLyrics out to a .lrc
+// sidecar (if --save-lrc) and/or embeds a USLT ID3 frame into tag (unless
+// --embed-lrc=false). The bogem/id3v2 library has no synchronised-lyrics
+// (SYLT) frame, so when only timestamped lyrics are available their text is
+// embedded as plain, unsynced lines.
+func applyLyrics(tag *id3v2.Tag, trackPath string, res resolvedLyrics) error {
+	if res.empty() {
+		return nil
+	}
+
+	plain := res.plain
+
+	if *saveLRC {
+		sidecar := strings.TrimSuffix(trackPath, ".mp3") + ".lrc"
+		body := res.lrcText
+		if body == "" {
+			body = plain
+		}
+		if err := os.WriteFile(sidecar, []byte(body), 0644); err != nil {
+			return fmt.Errorf("could not write lyrics sidecar: %w", err)
+		}
+	}
+
+	if !*embedLRC {
+		return nil
+	}
+
+	if plain == "" && len(res.synced) > 0 {
+		lines := make([]string, len(res.synced))
+		for i, line := range res.synced {
+			lines[i] = line.Text
+		}
+		plain = strings.Join(lines, "\n")
+	}
+
+	if plain != "" {
+		tag.AddUnsynchronisedLyricsFrame(id3v2.UnsynchronisedLyricsFrame{
+			Encoding:          id3v2.EncodingUTF8,
+			Language:          "eng",
+			ContentDescriptor: "",
+			Lyrics:            plain,
+		})
+	}
+
+	return nil
+}
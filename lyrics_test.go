@@ -0,0 +1,54 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseLRC(t *testing.T) {
+	tests := []struct {
+		name string
+		lrc  string
+		want []lrcLine
+	}{
+		{
+			name: "single timestamps",
+			lrc:  "[00:01.00]First line\n[00:02.50]Second line",
+			want: []lrcLine{
+				{TimestampMS: 1000, Text: "First line"},
+				{TimestampMS: 2500, Text: "Second line"},
+			},
+		},
+		{
+			name: "repeated-line multiple leading tags",
+			lrc:  "[00:01.00][00:02.00]Repeated line",
+			want: []lrcLine{
+				{TimestampMS: 1000, Text: "Repeated line"},
+				{TimestampMS: 2000, Text: "Repeated line"},
+			},
+		},
+		{
+			name: "metadata tag skipped",
+			lrc:  "[ar:Some Artist]\n[00:01.00]First line",
+			want: []lrcLine{
+				{TimestampMS: 1000, Text: "First line"},
+			},
+		},
+		{
+			name: "minutes beyond two digits",
+			lrc:  "[12:03.40]Late line",
+			want: []lrcLine{
+				{TimestampMS: 723400, Text: "Late line"},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := parseLRC(tt.lrc)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parseLRC(%q) = %+v, want %+v", tt.lrc, got, tt.want)
+			}
+		})
+	}
+}
@@ -1,7 +1,7 @@
 package main
 
 import (
-	"encoding/json"
+	"context"
 	"errors"
 	"flag"
 	"fmt"
@@ -9,17 +9,99 @@ import (
 	"net/http"
 	"net/url"
 	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
 	"strings"
-	"time"
+	"sync"
 
 	"github.com/bogem/id3v2/v2"
-	"golang.org/x/net/html"
 )
 
 var safeNames = flag.Bool("safeNames", true, "Convert artist, album, and track names to be safe in filesystems like NTFS")
+var albumFormat = flag.String("album-format", "{artist}/{album} ({year})", "Template for the album directory, may contain nested directory segments. Tokens: {artist} {album} {year} {albumArtist}")
+var trackFormat = flag.String("track-format", "{track:02} {title}", "Template for the track filename (without extension). Tokens: {artist} {album} {year} {albumArtist} {title} {track} {track:02}")
+var concurrency = flag.Int("concurrency", 4, "Maximum number of tracks to download in parallel. Shared across every album in flight, so downloading a whole discography doesn't multiply this by the number of albums")
+
+// downloadTokens is the single bounded worker pool every track download
+// acquires a slot from, whether it's one of an album's own tracks or one of
+// many albums being fetched concurrently by downloadBandcampDiscography.
+// Sharing one pool (instead of giving each album-level loop its own
+// *concurrency-sized pool nested inside the track-level one) is what keeps
+// --concurrency N an actual cap on simultaneous downloads rather than N
+// albums times N tracks. Sized once *concurrency is known, in main.
+var downloadTokens chan struct{}
+
+// tokenPattern matches template tokens such as {title} or {track:02}, where
+// the optional ":0N" suffix requests zero-padding to N digits for the track
+// number.
+var tokenPattern = regexp.MustCompile(`\{(\w+)(?::0(\d+))?\}`)
+
+// formatTemplate substitutes the tokens in tmpl with values from values and,
+// for {track} and {track:0N}, the given track number. Unknown tokens are left
+// untouched so typos are easy to spot in the resulting path. Token values are
+// run through sanitizePathValue first: values come from sources (Bandcamp
+// pages, arbitrary JSON/RSS feeds) we don't trust to not hand back something
+// like ".." or "../../etc" for {artist}/{album}/{title}.
+func formatTemplate(tmpl string, values map[string]string, track int) string {
+	return tokenPattern.ReplaceAllStringFunc(tmpl, func(m string) string {
+		groups := tokenPattern.FindStringSubmatch(m)
+		key, width := groups[1], groups[2]
+		if key == "track" {
+			if width == "" {
+				return strconv.Itoa(track)
+			}
+			w, _ := strconv.Atoi(width)
+			return fmt.Sprintf("%0*d", w, track)
+		}
+		if v, ok := values[key]; ok {
+			return sanitizePathValue(v)
+		}
+		return m
+	})
+}
+
+// sanitizePathValue makes s safe to substitute into a single path segment of
+// an album/track template: it neutralizes path separators so a value can't
+// introduce extra directory levels of its own, and neutralizes values that
+// are entirely "." or ".." so a token can't resolve to the current or parent
+// directory (e.g. an {artist} of ".." escaping the album directory).
+func sanitizePathValue(s string) string {
+	s = strings.NewReplacer("/", "⁄", "\\", "∖").Replace(s)
+	if s != "" && strings.Trim(s, ".") == "" {
+		s = strings.Repeat("·", len(s))
+	}
+	return s
+}
+
+// ensureWithinWorkingDir confirms that path doesn't resolve outside the
+// current working directory. It's a second line of defense behind
+// sanitizePathValue, called right before any album/track path gets used for
+// a filesystem write: a Source like feedSource hands back artist/album/track
+// fields straight from an arbitrary attacker-authored JSON/RSS URL, so they
+// get no more trust than any other network input.
+func ensureWithinWorkingDir(path string) error {
+	cwd, err := os.Getwd()
+	if err != nil {
+		return err
+	}
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return err
+	}
+	rel, err := filepath.Rel(cwd, abs)
+	if err != nil {
+		return err
+	}
+	if rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return fmt.Errorf("refusing to write outside the download directory: %s", path)
+	}
+	return nil
+}
 
 func main() {
 	flag.Parse()
+	downloadTokens = make(chan struct{}, *concurrency)
 
 	if len(flag.Args()) < 1 {
 		fmt.Println("Usage: bandit-dl <album-url> [<album-url> ...]")
@@ -35,166 +117,89 @@ func main() {
 		if parsed.Path == "/" || parsed.Path == "" {
 			parsed.Path = "/music"
 		}
+
 		if parsed.Path == "/music" || parsed.Path == "/music/" {
-			if err := downloadAlbums(*parsed); err != nil {
-				fmt.Println("❌", err)
-			}
-		} else if strings.HasPrefix(parsed.Path, "/album") {
-			if err := downloadAlbum(u); err != nil {
+			if err := downloadBandcampDiscography(*parsed); err != nil {
 				fmt.Println("❌", err)
 			}
-		} else {
-			fmt.Println("❌", "Invalid URL", u)
+			continue
 		}
-	}
 
-	fmt.Println("🎶 Thanks for using this tool and remember to support the musicians!")
-}
-
-type timestamp struct {
-	time.Time
-}
-
-func (t *timestamp) UnmarshalJSON(b []byte) error {
-	s := string(b)
-	s = s[1 : len(s)-1]
-	tt, err := time.Parse("02 Jan 2006 15:04:05 GMT", s)
-	if err != nil {
-		return err
-	}
-	t.Time = tt
-	return nil
-}
-
-type bandcampTRAlbum struct {
-	Artist  string `json:"artist"`
-	Current struct {
-		Title string `json:"title"`
-		ArtId int    `json:"art_id"`
-	} `json:"current"`
-	ItemType         string    `json:"item_type"`
-	FreeDownloadPage string    `json:"freeDownloadPage"`
-	ReleaseDate      timestamp `json:"album_release_date"`
-	Trackinfo        []struct {
-		Title    string `json:"title"`
-		TrackNum int    `json:"track_num"`
-		File     struct {
-			Url string `json:"mp3-128"`
-		} `json:"file"`
-	} `json:"trackinfo"`
-}
-
-func downloadAlbums(url url.URL) (err error) {
-	resp, err := http.Get(url.String())
-	if err != nil {
-		return err
-	}
-	defer resp.Body.Close()
-
-	doc, err := html.Parse(resp.Body)
-	if err != nil {
-		return err
-	}
-
-	els := findElementsWithDataKey(doc, "data-item-id")
-	for _, el := range els {
-		url.Path = ""
-		for c := el.FirstChild; c != nil; c = c.NextSibling {
-			if c.Type == html.TextNode || c.Data != "a" {
-				continue
-			}
-			for _, a := range c.Attr {
-				if a.Key == "href" {
-					url.Path = a.Val
-					break
-				}
-			}
-			if url.Path != "" {
-				break
-			}
+		source := findSource(parsed)
+		if source == nil {
+			fmt.Println("❌", "No source recognizes URL", u)
+			continue
 		}
-		if strings.HasPrefix(url.Path, "/album") {
-			if err2 := downloadAlbum(url.String()); err2 != nil {
-				err = fmt.Errorf("%w\n%s", err, err2)
-			}
+		if err := downloadFromSource(source, parsed); err != nil {
+			fmt.Println("❌", err)
 		}
 	}
 
-	return err
+	fmt.Println("🎶 Thanks for using this tool and remember to support the musicians!")
 }
 
-func downloadAlbum(url string) error {
-	resp, err := http.Get(url)
-	if err != nil {
-		return err
-	}
-	defer resp.Body.Close()
-
-	doc, err := html.Parse(resp.Body)
+// downloadFromSource fetches the album at u using source and writes it to
+// disk.
+func downloadFromSource(source Source, u *url.URL) error {
+	album, err := source.FetchAlbum(context.Background(), u)
 	if err != nil {
 		return err
 	}
+	return downloadAlbum(album)
+}
 
-	el := findElementsWithDataKey(doc, "data-tralbum")
-
-	if el == nil {
-		return fmt.Errorf("could not find album")
-	}
-
-	tralbumValue := getDataValue(el[0], "data-tralbum")
-
-	var tralbum bandcampTRAlbum
-
-	if err := json.Unmarshal([]byte(tralbumValue), &tralbum); err != nil {
-		return fmt.Errorf("could not parse album JSON: %w", err)
-	}
-
+// downloadAlbum is the single writer path shared by every Source: it
+// handles safe naming, path templating, album art, concurrent track
+// downloads with progress reporting, ID3 tagging, lyrics, and the
+// resume/verify manifest.
+func downloadAlbum(album *Album) error {
 	if *safeNames {
-		tralbum.Artist = cleanString(tralbum.Artist)
-		tralbum.Current.Title = cleanString(tralbum.Current.Title)
-		for i, track := range tralbum.Trackinfo {
-			tralbum.Trackinfo[i].Title = cleanString(track.Title)
+		album.Artist = cleanString(album.Artist)
+		album.AlbumArtist = cleanString(album.AlbumArtist)
+		album.Title = cleanString(album.Title)
+		for i, track := range album.Tracks {
+			album.Tracks[i].Title = cleanString(track.Title)
 		}
 	}
 
-	if tralbum.FreeDownloadPage != "" {
+	if album.FreeDownloadPage != "" {
 		fmt.Println("This album is free to download in higher quality formats!")
-		fmt.Printf("  %s\n", tralbum.FreeDownloadPage)
+		fmt.Printf("  %s\n", album.FreeDownloadPage)
 	}
 
-	fmt.Println("Downloading", tralbum.Artist, tralbum.Current.Title, tralbum.ReleaseDate.Local().Format("2006"))
+	fmt.Println("Downloading", album.Artist, album.Title, album.Year)
 
-	// Fetch the album art, if one exists.
+	// Fetch the album art, if the source has any.
 	var artBytes []byte
 	var bigArtBytes []byte
-	if tralbum.Current.ArtId != 0 {
-		// Acquire a smaller one for embedding in id3.
-		artUrl := fmt.Sprintf("https://f4.bcbits.com/img/a%d_16.jpg", tralbum.Current.ArtId)
-		resp, err := http.Get(artUrl)
+	if album.ArtSmallURL != "" {
+		var err error
+		artBytes, err = fetchBytes(album.ArtSmallURL)
 		if err != nil {
 			return fmt.Errorf("could not fetch album art: %w", err)
 		}
-		defer resp.Body.Close()
-		artBytes, err = io.ReadAll(resp.Body)
-		if err != nil {
-			return errors.New("could not read album art")
-		}
-
-		// Get the full-sized one to store in the local dir.
-		artUrl = fmt.Sprintf("https://f4.bcbits.com/img/a%d_0.jpg", tralbum.Current.ArtId)
-		resp, err = http.Get(artUrl)
+	}
+	if album.ArtLargeURL != "" {
+		var err error
+		bigArtBytes, err = fetchBytes(album.ArtLargeURL)
 		if err != nil {
 			return fmt.Errorf("could not fetch large album art: %w", err)
 		}
-		defer resp.Body.Close()
-		bigArtBytes, err = io.ReadAll(resp.Body)
-		if err != nil {
-			return errors.New("could not read large album art")
-		}
 	}
 
-	// Create artist/album directory.
-	albumPath := fmt.Sprintf("%s/%s (%s)", tralbum.Artist, tralbum.Current.Title, tralbum.ReleaseDate.Local().Format("2006"))
+	pathValues := map[string]string{
+		"artist":      album.Artist,
+		"album":       album.Title,
+		"year":        album.Year,
+		"albumArtist": album.AlbumArtist,
+	}
+
+	// Create artist/album directory. The template may contain nested
+	// directory segments (e.g. "{albumArtist}/{album} ({year})").
+	albumPath := filepath.FromSlash(formatTemplate(*albumFormat, pathValues, 0))
+	if err := ensureWithinWorkingDir(albumPath); err != nil {
+		return err
+	}
 
 	if _, err := os.Stat(albumPath); os.IsNotExist(err) {
 		if err := os.MkdirAll(albumPath, 0755); err != nil {
@@ -215,86 +220,218 @@ func downloadAlbum(url string) error {
 		f.Close()
 	}
 
-	for _, track := range tralbum.Trackinfo {
-		fmt.Printf(" %d %s ", track.TrackNum, track.Title)
-		resp, err := http.Get(track.File.Url)
-		if err != nil {
-			return fmt.Errorf("could not fetch track: %w", err)
+	if *format != "mp3-128" && album.FreeDownloadPage != "" {
+		if err := downloadFreeFormatTracks(album, albumPath, pathValues, artBytes); err != nil {
+			fmt.Println("⚠️ could not get free", *format, "download, falling back to mp3-128:", err)
+		} else {
+			fmt.Println("🎶 Downloaded", *format, "files from the free download page")
+			return nil
 		}
-		defer resp.Body.Close()
-
-		trackPath := fmt.Sprintf("%s/%02d %s.mp3", albumPath, track.TrackNum, track.Title)
+	}
 
-		f, err := os.Create(trackPath)
+	var oldManifest *albumManifest
+	if *verify {
+		m, err := loadManifest(albumPath)
 		if err != nil {
-			return fmt.Errorf("could not create track file: %w", err)
+			return fmt.Errorf("could not load manifest: %w", err)
 		}
+		oldManifest = m
+	}
 
-		_, err = f.ReadFrom(resp.Body)
-		if err != nil {
-			return fmt.Errorf("could not write track file: %w", err)
+	bar := newProgressBar()
+	var (
+		mu          sync.Mutex
+		tagMu       sync.Mutex
+		errs        []error
+		manifestOut []manifestTrack
+		wg          sync.WaitGroup
+	)
+	for i, track := range album.Tracks {
+		i, track := i, track
+		wg.Add(1)
+		downloadTokens <- struct{}{}
+		go func() {
+			defer wg.Done()
+			defer func() { <-downloadTokens }()
+
+			entry, err := downloadTrack(bar, i, albumPath, pathValues, album, track, &tagMu, artBytes, oldManifest)
+			if err != nil {
+				mu.Lock()
+				errs = append(errs, fmt.Errorf("track %d (%s): %w", track.TrackNum, track.Title, err))
+				mu.Unlock()
+				return
+			}
+			if *verify {
+				mu.Lock()
+				manifestOut = append(manifestOut, entry)
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+
+	if *verify && len(errs) == 0 {
+		m := &albumManifest{Artist: album.Artist, Album: album.Title, Year: album.Year, Tracks: manifestOut}
+		if err := saveManifest(albumPath, m); err != nil {
+			errs = append(errs, fmt.Errorf("could not write manifest: %w", err))
 		}
-		f.Close()
+	}
+
+	return errors.Join(errs...)
+}
+
+// downloadTrack fetches a single track, writes it to disk while reporting
+// progress to bar, and then applies its ID3 tags while holding tagMu, so
+// only one track is tagged at a time even though downloads run concurrently.
+// When --resume is set, a local file matching the remote size is left alone
+// and a partial one is resumed with a Range request; when --verify is set,
+// a local file matching oldManifest's recorded hash is trusted without
+// re-hashing the download, and the returned manifestTrack is used to build
+// the album's new manifest.json.
+func downloadTrack(bar *progressBar, id int, albumPath string, pathValues map[string]string, album *Album, track Track, tagMu *sync.Mutex, artBytes []byte, oldManifest *albumManifest) (manifestTrack, error) {
+	values := make(map[string]string, len(pathValues)+1)
+	for k, v := range pathValues {
+		values[k] = v
+	}
+	values["title"] = track.Title
+	trackName := filepath.FromSlash(formatTemplate(*trackFormat, values, track.TrackNum)) + ".mp3"
+	trackPath := filepath.Join(albumPath, trackName)
+	if err := ensureWithinWorkingDir(trackPath); err != nil {
+		return manifestTrack{}, err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(trackPath), 0755); err != nil {
+		return manifestTrack{}, fmt.Errorf("could not create track directory: %w", err)
+	}
+
+	label := fmt.Sprintf("%02d %s", track.TrackNum, track.Title)
+
+	skip, stat, err := shouldSkipDownload(trackPath, track.URL, oldManifest.find(trackName))
+	if err != nil {
+		return manifestTrack{}, err
+	}
+	if skip {
+		bar.update(id, label, stat.Size(), stat.Size())
+	} else if err := fetchTrackFile(bar, id, label, trackPath, track.URL); err != nil {
+		return manifestTrack{}, err
+	}
 
-		// Add ID3 tags.
-		tag, err := id3v2.Open(trackPath, id3v2.Options{Parse: true})
+	entry := manifestTrack{Filename: trackName, Source: track.URL}
+	if *verify {
+		sha, size, err := sha256File(trackPath)
 		if err != nil {
-			return fmt.Errorf("could not open track file: %w", err)
-		}
-		defer tag.Close()
-
-		tag.SetArtist(tralbum.Artist)
-		tag.SetAlbum(tralbum.Current.Title)
-		tag.SetYear(tralbum.ReleaseDate.Local().Format("2006"))
-		tag.SetTitle(track.Title)
-		tag.AddTextFrame("TRCK", id3v2.EncodingUTF8, fmt.Sprintf("%d", track.TrackNum))
-
-		if artBytes != nil {
-			pic := id3v2.PictureFrame{
-				Encoding:    id3v2.EncodingUTF8,
-				MimeType:    "image/jpeg",
-				PictureType: id3v2.PTFrontCover,
-				Description: "Front cover",
-				Picture:     artBytes,
-			}
-			tag.AddAttachedPicture(pic)
+			return manifestTrack{}, fmt.Errorf("could not hash track file: %w", err)
 		}
+		entry.SHA256, entry.Size = sha, size
+	}
 
-		if err := tag.Save(); err != nil {
-			return fmt.Errorf("could not save track file: %w", err)
-		}
-		fmt.Printf("✔️ \n")
+	// Resolve lyrics before taking tagMu: it may hit an external provider
+	// over the network, and that shouldn't serialize every other track's
+	// (already on-disk) tagging behind it.
+	lyrics, lyricsErr := resolveLyrics(album.Artist, album.Title, track.Title, track.Duration, track.Lyrics)
+	if lyricsErr != nil {
+		fmt.Println("⚠️ could not fetch lyrics for", track.Title, lyricsErr)
 	}
-	fmt.Println()
-	return nil
-}
 
-func findElementsWithDataKey(n *html.Node, key string) []*html.Node {
-	var results []*html.Node
+	tagMu.Lock()
+	defer tagMu.Unlock()
 
-	if n.Type == html.ElementNode {
-		for _, a := range n.Attr {
-			if a.Key == key {
-				results = append(results, n)
-			}
+	tag, err := id3v2.Open(trackPath, id3v2.Options{Parse: true})
+	if err != nil {
+		return manifestTrack{}, fmt.Errorf("could not open track file: %w", err)
+	}
+	defer tag.Close()
+
+	tag.SetArtist(album.Artist)
+	tag.SetAlbum(album.Title)
+	tag.SetYear(album.Year)
+	tag.SetTitle(track.Title)
+	tag.AddTextFrame("TRCK", id3v2.EncodingUTF8, fmt.Sprintf("%d", track.TrackNum))
+
+	if artBytes != nil {
+		pic := id3v2.PictureFrame{
+			Encoding:    id3v2.EncodingUTF8,
+			MimeType:    "image/jpeg",
+			PictureType: id3v2.PTFrontCover,
+			Description: "Front cover",
+			Picture:     artBytes,
 		}
+		tag.AddAttachedPicture(pic)
 	}
 
-	for c := n.FirstChild; c != nil; c = c.NextSibling {
-		results = append(results, findElementsWithDataKey(c, key)...)
+	if lyricsErr == nil {
+		if err := applyLyrics(tag, trackPath, lyrics); err != nil {
+			fmt.Println("⚠️ could not save lyrics for", track.Title, err)
+		}
 	}
 
-	return results
+	if err := tag.Save(); err != nil {
+		return manifestTrack{}, fmt.Errorf("could not save track file: %w", err)
+	}
+	return entry, nil
 }
 
-func getDataValue(n *html.Node, key string) string {
-	for _, a := range n.Attr {
-		if a.Key == key {
-			return a.Val
+// fetchTrackFile downloads url to trackPath, reporting progress to bar. When
+// --resume is set and trackPath already has a partial download, it issues a
+// Range request and appends instead of starting over.
+func fetchTrackFile(bar *progressBar, id int, label, trackPath, url string) error {
+	var offset int64
+	if *resume {
+		if stat, err := os.Stat(trackPath); err == nil {
+			offset = stat.Size()
 		}
 	}
 
-	return ""
+	req, err := http.NewRequest(http.MethodGet, url, nil)
+	if err != nil {
+		return fmt.Errorf("could not build track request: %w", err)
+	}
+	if offset > 0 {
+		req.Header.Set("Range", fmt.Sprintf("bytes=%d-", offset))
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("could not fetch track: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if offset > 0 && resp.StatusCode != http.StatusPartialContent {
+		// Server ignored the Range request; start the file over.
+		offset = 0
+	}
+
+	flags := os.O_CREATE | os.O_WRONLY
+	if offset > 0 {
+		flags |= os.O_APPEND
+	} else {
+		flags |= os.O_TRUNC
+	}
+	f, err := os.OpenFile(trackPath, flags, 0644)
+	if err != nil {
+		return fmt.Errorf("could not create track file: %w", err)
+	}
+	defer f.Close()
+
+	total := resp.ContentLength
+	if offset > 0 && total > 0 {
+		total += offset
+	}
+	pw := &progressWriter{bar: bar, id: id, label: label, total: total, read: offset}
+	if _, err := io.Copy(f, io.TeeReader(resp.Body, pw)); err != nil {
+		return fmt.Errorf("could not write track file: %w", err)
+	}
+	return nil
+}
+
+// fetchBytes GETs url and returns its full body.
+func fetchBytes(url string) ([]byte, error) {
+	resp, err := http.Get(url)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	return io.ReadAll(resp.Body)
 }
 
 // TODO: Make this user configurable?
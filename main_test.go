@@ -0,0 +1,57 @@
+package main
+
+import "testing"
+
+func TestFormatTemplate(t *testing.T) {
+	values := map[string]string{
+		"artist":      "Example Artist",
+		"album":       "Example Album",
+		"year":        "2021",
+		"albumArtist": "Various Artists",
+		"title":       "Example Title",
+	}
+
+	tests := []struct {
+		name   string
+		tmpl   string
+		values map[string]string
+		want   string
+	}{
+		{"basic tokens", "{artist}/{album} ({year})", values, "Example Artist/Example Album (2021)"},
+		{"unpadded track", "{track} {title}", values, "3 Example Title"},
+		{"padded track", "{track:02} {title}", values, "03 Example Title"},
+		{"wider padding", "{track:03}", values, "003"},
+		{"unknown token left alone", "{nope}", values, "{nope}"},
+		{"traversal value neutralized", "{artist}/{album}", map[string]string{"artist": "..", "album": "x"}, "··/x"},
+		{"embedded slash neutralized", "{artist}", map[string]string{"artist": "a/b"}, "a⁄b"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := formatTemplate(tt.tmpl, tt.values, 3); got != tt.want {
+				t.Errorf("formatTemplate(%q) = %q, want %q", tt.tmpl, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSanitizePathValue(t *testing.T) {
+	tests := []struct {
+		in   string
+		want string
+	}{
+		{"Example Artist", "Example Artist"},
+		{"..", "··"},
+		{".", "·"},
+		{"...", "···"},
+		{"a/b", "a⁄b"},
+		{`a\b`, "a∖b"},
+		{"", ""},
+	}
+
+	for _, tt := range tests {
+		if got := sanitizePathValue(tt.in); got != tt.want {
+			t.Errorf("sanitizePathValue(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
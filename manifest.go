@@ -0,0 +1,137 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+)
+
+var resume = flag.Bool("resume", false, "Skip tracks whose local file already matches the remote size, or resume a partially downloaded track")
+var verify = flag.Bool("verify", false, "Write a manifest.json of each track's SHA-256 in the album directory, and re-download tracks that don't match it on a later run")
+
+const manifestFilename = "manifest.json"
+
+// manifestTrack records what was downloaded for a single track, so a later
+// --verify run can tell whether the local file is still intact.
+type manifestTrack struct {
+	Filename string `json:"filename"`
+	Size     int64  `json:"size"`
+	SHA256   string `json:"sha256"`
+	Source   string `json:"source"`
+}
+
+type albumManifest struct {
+	Artist string          `json:"artist"`
+	Album  string          `json:"album"`
+	Year   string          `json:"year"`
+	Tracks []manifestTrack `json:"tracks"`
+}
+
+// loadManifest reads manifest.json from albumPath. A missing manifest is not
+// an error; it returns a nil *albumManifest instead.
+func loadManifest(albumPath string) (*albumManifest, error) {
+	b, err := os.ReadFile(filepath.Join(albumPath, manifestFilename))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var m albumManifest
+	if err := json.Unmarshal(b, &m); err != nil {
+		return nil, fmt.Errorf("could not parse manifest: %w", err)
+	}
+	return &m, nil
+}
+
+// find returns the recorded entry for filename, or nil if m is nil or has no
+// such entry.
+func (m *albumManifest) find(filename string) *manifestTrack {
+	if m == nil {
+		return nil
+	}
+	for i := range m.Tracks {
+		if m.Tracks[i].Filename == filename {
+			return &m.Tracks[i]
+		}
+	}
+	return nil
+}
+
+func saveManifest(albumPath string, m *albumManifest) error {
+	b, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(albumPath, manifestFilename), b, 0644)
+}
+
+// sha256File hashes the file at path, returning the hex digest and its size.
+func sha256File(path string) (sha string, size int64, err error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", 0, err
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	size, err = io.Copy(h, f)
+	if err != nil {
+		return "", 0, err
+	}
+	return hex.EncodeToString(h.Sum(nil)), size, nil
+}
+
+// remoteSize returns the Content-Length reported for url via a HEAD request,
+// or -1 if the server didn't report one.
+func remoteSize(url string) (int64, error) {
+	resp, err := http.Head(url)
+	if err != nil {
+		return -1, err
+	}
+	defer resp.Body.Close()
+	if resp.ContentLength <= 0 {
+		return -1, nil
+	}
+	return resp.ContentLength, nil
+}
+
+// shouldSkipDownload decides whether trackPath is already complete and can be
+// left alone: under --verify, a size and SHA-256 match against old is
+// trusted; under --resume, a size match against the remote Content-Length is
+// trusted. It returns the os.FileInfo of the existing file so the caller can
+// report it as already-complete progress.
+func shouldSkipDownload(trackPath, url string, old *manifestTrack) (bool, os.FileInfo, error) {
+	stat, err := os.Stat(trackPath)
+	if os.IsNotExist(err) {
+		return false, nil, nil
+	}
+	if err != nil {
+		return false, nil, err
+	}
+
+	if *verify && old != nil && old.Size == stat.Size() {
+		sha, _, err := sha256File(trackPath)
+		if err != nil {
+			return false, nil, fmt.Errorf("could not hash track file: %w", err)
+		}
+		if sha == old.SHA256 {
+			return true, stat, nil
+		}
+	}
+
+	if *resume {
+		size, err := remoteSize(url)
+		if err == nil && size > 0 && size == stat.Size() {
+			return true, stat, nil
+		}
+	}
+
+	return false, stat, nil
+}
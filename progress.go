@@ -0,0 +1,85 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+)
+
+// progressBar renders one line per in-flight track download, redrawing them
+// in place with ANSI cursor movement as bytes come in.
+type progressBar struct {
+	mu    sync.Mutex
+	order []int
+	lines map[int]string
+	drawn int
+}
+
+func newProgressBar() *progressBar {
+	return &progressBar{lines: make(map[int]string)}
+}
+
+// update sets the line for id and redraws the whole bar.
+func (p *progressBar) update(id int, label string, read, total int64) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if _, ok := p.lines[id]; !ok {
+		p.order = append(p.order, id)
+	}
+	p.lines[id] = formatProgressLine(label, read, total)
+
+	if p.drawn > 0 {
+		fmt.Printf("\x1b[%dA", p.drawn)
+	}
+	for _, id := range p.order {
+		fmt.Printf("\x1b[2K%s\n", p.lines[id])
+	}
+	p.drawn = len(p.order)
+}
+
+func formatProgressLine(label string, read, total int64) string {
+	const width = 24
+	filled := 0
+	if total > 0 {
+		filled = int(float64(width) * float64(read) / float64(total))
+		if filled > width {
+			filled = width
+		}
+	}
+	bar := strings.Repeat("█", filled) + strings.Repeat("░", width-filled)
+	return fmt.Sprintf(" %-30s [%s] %8s/%8s", label, bar, humanBytes(read), humanBytes(total))
+}
+
+func humanBytes(n int64) string {
+	if n <= 0 {
+		return "?"
+	}
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%dB", n)
+	}
+	div, exp := int64(unit), 0
+	for v := n / unit; v >= unit; v /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f%ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
+
+// progressWriter is an io.Writer that reports every write to bar as progress
+// for track id. It's meant to be paired with an io.TeeReader on the
+// download's response body.
+type progressWriter struct {
+	bar   *progressBar
+	id    int
+	label string
+	total int64
+	read  int64
+}
+
+func (w *progressWriter) Write(p []byte) (int, error) {
+	w.read += int64(len(p))
+	w.bar.update(w.id, w.label, w.read, w.total)
+	return len(p), nil
+}
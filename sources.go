@@ -0,0 +1,56 @@
+package main
+
+import (
+	"context"
+	"net/url"
+)
+
+// Track is a single downloadable audio file belonging to an Album, as
+// resolved by a Source. TrackNum and Duration are zero when a source can't
+// supply them.
+type Track struct {
+	Title    string
+	TrackNum int
+	Duration float64 // seconds
+	URL      string
+	Lyrics   string // plain lyrics, empty if the source has none
+}
+
+// Album is the neutral representation every Source produces. The writer
+// path in downloadAlbum turns it into files on disk without needing to know
+// which Source produced it.
+type Album struct {
+	Artist           string
+	AlbumArtist      string
+	Title            string
+	Year             string
+	ArtSmallURL      string // embedded into ID3 tags; empty if unavailable
+	ArtLargeURL      string // saved as cover.jpg; empty if unavailable
+	FreeDownloadPage string
+	Tracks           []Track
+}
+
+// Source knows how to recognize and fetch albums from one kind of page or
+// feed.
+type Source interface {
+	// Match reports whether this Source can handle u.
+	Match(u *url.URL) bool
+	// FetchAlbum retrieves and parses the album at u.
+	FetchAlbum(ctx context.Context, u *url.URL) (*Album, error)
+}
+
+// sources lists the registered Sources in priority order. findSource returns
+// the first one whose Match reports true for a given URL.
+var sources = []Source{
+	bandcampSource{},
+	feedSource{},
+}
+
+func findSource(u *url.URL) Source {
+	for _, s := range sources {
+		if s.Match(u) {
+			return s
+		}
+	}
+	return nil
+}